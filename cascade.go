@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/awnumar/memguard"
+)
+
+// CascadeOverhead is the size by which a cascade ciphertext exceeds the plaintext.
+const CascadeOverhead int = (12 + 16) + (24 + 16) // AES-GCM nonce + auth, XChaCha20-Poly1305 nonce + auth
+
+// cascadeInfo is the HKDF context string used to derive the cascade subkeys.
+var cascadeInfo = []byte("gravity cascade v1")
+
+/*
+EncryptCascade takes a plaintext message and a 32 byte key and returns an authenticated ciphertext that is sealed by two independent algorithms.
+
+The message is first sealed with AES-256-GCM and the result is then sealed with XChaCha20-Poly1305, each under its own subkey derived from the given key with HKDF-SHA256. An attacker must break both algorithms to recover the plaintext. This costs roughly twice as much as Encrypt, plus a key derivation per call, and the ciphertext is CascadeOverhead rather than Overhead bytes larger than the plaintext.
+*/
+func EncryptCascade(plaintext, key []byte) ([]byte, error) {
+	// Check the length of the key is correct.
+	if len(key) != 32 {
+		return nil, ErrInvalidKeyLength
+	}
+
+	// Check the key is not trivially weak.
+	if weakKey(key) {
+		return nil, ErrWeakKey
+	}
+
+	inner, outer, err := cascadeCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Seal the plaintext with AES-GCM.
+	nonce := make([]byte, inner.NonceSize(), inner.NonceSize()+len(plaintext)+inner.Overhead())
+	randomBytes(nonce)
+	x := inner.Seal(nonce, nonce, plaintext, nil)
+	defer memguard.WipeBytes(x)
+
+	// Seal the result with XChaCha20-Poly1305.
+	nonce = make([]byte, outer.NonceSize(), outer.NonceSize()+len(x)+outer.Overhead())
+	randomBytes(nonce)
+	return outer.Seal(nonce, nonce, x, nil), nil
+}
+
+/*
+DecryptCascade decrypts a ciphertext produced by EncryptCascade with a given 32 byte key and writes the result to the start of a given buffer.
+
+The buffer must be large enough to contain the decrypted data, which is CascadeOverhead bytes less than the length of the ciphertext. The size of the decrypted data is returned.
+*/
+func DecryptCascade(ciphertext, key []byte, output []byte) (int, error) {
+	// Check the length of the key is correct.
+	if len(key) != 32 {
+		return 0, ErrInvalidKeyLength
+	}
+
+	// Check the ciphertext is large enough to contain both layers.
+	if len(ciphertext) < CascadeOverhead {
+		return 0, ErrDecryptionFailed
+	}
+
+	// Check the capacity of the given output buffer.
+	if cap(output) < (len(ciphertext) - CascadeOverhead) {
+		return 0, ErrBufferTooSmall
+	}
+
+	inner, outer, err := cascadeCiphers(key)
+	if err != nil {
+		return 0, err
+	}
+
+	// Open the XChaCha20-Poly1305 layer.
+	x, err := outer.Open(nil, ciphertext[:outer.NonceSize()], ciphertext[outer.NonceSize():], nil)
+	if err != nil {
+		return 0, ErrDecryptionFailed
+	}
+	defer memguard.WipeBytes(x)
+
+	// Open the AES-GCM layer.
+	m, err := inner.Open(nil, x[:inner.NonceSize()], x[inner.NonceSize():], nil)
+	if err != nil {
+		return 0, ErrDecryptionFailed
+	}
+	copy(output[:cap(output)], m) // Move plaintext to given output buffer.
+	memguard.WipeBytes(m)         // Wipe source buffer.
+	return len(m), nil            // Return length of decrypted plaintext.
+}
+
+// cascadeCiphers derives the two cascade subkeys from a 32 byte key and returns the AES-GCM and XChaCha20-Poly1305 instances keyed with them.
+func cascadeCiphers(key []byte) (inner, outer cipher.AEAD, err error) {
+	// Check the length of the key is correct.
+	if len(key) != 32 {
+		return nil, nil, ErrInvalidKeyLength
+	}
+
+	// Derive both subkeys into a secure buffer.
+	subkeys := memguard.NewBuffer(64)
+	defer subkeys.Destroy()
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, cascadeInfo), subkeys.Bytes()); err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(subkeys.Bytes()[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	if inner, err = cipher.NewGCM(block); err != nil {
+		return nil, nil, err
+	}
+	if outer, err = chacha20poly1305.NewX(subkeys.Bytes()[32:]); err != nil {
+		return nil, nil, err
+	}
+	return inner, outer, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+func TestEncryptDecryptCascade(t *testing.T) {
+	m := make([]byte, 64)
+	memguard.ScrambleBytes(m)
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+
+	// Encrypt the message.
+	x, err := EncryptCascade(m, k)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if len(x) != len(m)+CascadeOverhead {
+		t.Error("unexpected ciphertext length; got", len(x))
+	}
+
+	// Decrypt the message.
+	dm := make([]byte, len(x)-CascadeOverhead)
+	length, err := DecryptCascade(x, k, dm)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if length != len(m) {
+		t.Error("unexpected plaintext length; got", length)
+	}
+	if !bytes.Equal(m, dm) {
+		t.Error("decrypted plaintext does not match original")
+	}
+
+	// Attempt decryption /w buffer that is too small to hold the output.
+	length, err = DecryptCascade(x, k, make([]byte, len(m)-1))
+	if err != ErrBufferTooSmall || length != 0 {
+		t.Error("expected ErrBufferTooSmall; got", length, err)
+	}
+
+	// Attempt decryption with an incorrect key.
+	ik := make([]byte, 32)
+	memguard.ScrambleBytes(ik)
+	length, err = DecryptCascade(x, ik, dm)
+	if err != ErrDecryptionFailed || length != 0 {
+		t.Error("expected error with incorrect key; got", length, err)
+	}
+
+	// Tamper with the outer layer.
+	for i := range x {
+		y := append([]byte{}, x...)
+		y[i] ^= 0x01
+		if length, err := DecryptCascade(y, k, dm); err != ErrDecryptionFailed || length != 0 {
+			t.Error("expected error with modified outer layer at byte", i, "; got", err)
+		}
+	}
+
+	// Tamper with the inner layer and reseal the outer layer so that only the inner check can catch it.
+	inner, outer, err := cascadeCiphers(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := outer.NonceSize()
+	ix, err := outer.Open(nil, x[:ns], x[ns:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ix) != len(m)+inner.NonceSize()+inner.Overhead() {
+		t.Error("unexpected inner ciphertext length; got", len(ix))
+	}
+	ix[len(ix)/2] ^= 0x01
+	y := outer.Seal(append([]byte{}, x[:ns]...), x[:ns], ix, nil)
+	if length, err := DecryptCascade(y, k, dm); err != ErrDecryptionFailed || length != 0 {
+		t.Error("expected error with modified inner layer; got", err)
+	}
+
+	// Short ciphertexts are rejected.
+	if length, err := DecryptCascade(x[:CascadeOverhead-1], k, dm); err != ErrDecryptionFailed || length != 0 {
+		t.Error("expected error with short ciphertext; got", err)
+	}
+
+	// Keys of invalid length are rejected.
+	if ix, err := EncryptCascade(m, k[:16]); err != ErrInvalidKeyLength || ix != nil {
+		t.Error("expected error with invalid key; got", err)
+	}
+	if length, err := DecryptCascade(x, k[:16], dm); err != ErrInvalidKeyLength || length != 0 {
+		t.Error("expected error with invalid key; got", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/awnumar/memguard"
+	"github.com/prologic/bitcask"
+)
+
+// ChunkSize is the size of a padded chunk of file metadata or contents. Each chunk holds up to ChunkSize-1 bytes of data, since padding always takes up at least one byte.
+const ChunkSize = 4096
+
+// ErrInvalidChunk is returned when a stored chunk decrypts to something other than a single padded chunk.
+var ErrInvalidChunk = errors.New("<gravity::store::ErrInvalidChunk> stored chunk has an invalid size")
+
+// ChunkKeys holds the keys used to seal and open the chunks of a pocket.
+type ChunkKeys struct {
+	metadata *memguard.LockedBuffer
+	content  *memguard.LockedBuffer
+	pocket   *memguard.LockedBuffer // Only used to open chunks written by older versions.
+}
+
+// ChunkKeys unlocks the keys used to seal and open the pocket's chunks. The caller is responsible for calling Destroy on the result.
+func (p *Pocket) ChunkKeys() (*ChunkKeys, error) {
+	metadata, content, err := p.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+	pocket, err := p.Key.Open()
+	if err != nil {
+		metadata.Destroy()
+		content.Destroy()
+		return nil, err
+	}
+	return &ChunkKeys{metadata, content, pocket}, nil
+}
+
+// Destroy destroys the keys.
+func (k *ChunkKeys) Destroy() {
+	k.metadata.Destroy()
+	k.content.Destroy()
+	k.pocket.Destroy()
+}
+
+// subkey returns the key that chunks of the given type are sealed under.
+func (k *ChunkKeys) subkey(metadata bool) []byte {
+	if metadata {
+		return k.metadata.Bytes()
+	}
+	return k.content.Bytes()
+}
+
+// sealChunk pads the first n bytes of a ChunkSize buffer and seals the result under the given identifier, with the metadata or the content subkey. If cascade is set the chunk is sealed with SealCascade, which costs roughly twice as much.
+func sealChunk(keys *ChunkKeys, id, chunk []byte, n int, metadata, cascade bool) error {
+	if len(chunk) != ChunkSize {
+		return ErrInvalidChunk
+	}
+	if err := Pad(chunk, n); err != nil {
+		return err
+	}
+	if cascade {
+		return SealCascade(id, chunk, keys.subkey(metadata))
+	}
+	return Seal(id, chunk, keys.subkey(metadata))
+}
+
+/*
+openChunk opens the chunk stored under the given identifier into a ChunkSize buffer and returns the length of the data within it. ErrEntryNotFound is returned if there is no such chunk.
+
+Chunks sealed with the cascade are told apart by the size of their ciphertext, which is always ChunkSize plus either Overhead or CascadeOverhead.
+
+Chunks written by older versions are read as well. Before separate subkeys were introduced, chunks were sealed under the pocket key bound to their identifier, and before that under the pocket key itself. These are tried in turn if the current format fails to decrypt, so existing stores remain readable; they are never written.
+*/
+func openChunk(keys *ChunkKeys, id, chunk []byte, metadata bool) (int, error) {
+	if len(chunk) != ChunkSize {
+		return 0, ErrInvalidChunk
+	}
+	ct, err := Get(id)
+	if err != nil {
+		if err == bitcask.ErrKeyNotFound {
+			return 0, ErrEntryNotFound
+		}
+		return 0, err
+	}
+
+	var n int
+	if len(ct) == ChunkSize+CascadeOverhead {
+		n, err = OpenCascade(id, keys.subkey(metadata), chunk)
+	} else {
+		n, err = Open(id, keys.subkey(metadata), chunk)
+		if err == ErrDecryptionFailed {
+			n, err = Open(id, keys.pocket.Bytes(), chunk)
+		}
+		if err == ErrDecryptionFailed {
+			n, err = Decrypt(ct, keys.pocket.Bytes(), chunk)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n != ChunkSize {
+		return 0, ErrInvalidChunk
+	}
+	return Unpad(chunk)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+func testChunkKeys(t *testing.T) *ChunkKeys {
+	t.Helper()
+	defer useFastKDF()()
+
+	pocket, err := GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine")))
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	keys, err := pocket.ChunkKeys()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	return keys
+}
+
+func TestSealOpenChunk(t *testing.T) {
+	keys := testChunkKeys(t)
+	defer keys.Destroy()
+
+	id := make([]byte, 32)
+	memguard.ScrambleBytes(id)
+	data := []byte("chunk data")
+
+	for _, cascade := range []bool{false, true} {
+		for _, metadata := range []bool{true, false} {
+			chunk := make([]byte, ChunkSize)
+			copy(chunk, data)
+			if err := sealChunk(keys, id, chunk, len(data), metadata, cascade); err != nil {
+				t.Fatal("expected no errors; got", err)
+			}
+			if ct, _ := Get(id); cascade && len(ct) != ChunkSize+CascadeOverhead {
+				t.Error("chunk not sealed with the cascade")
+			}
+
+			// The chunk opens as the type it was sealed as.
+			out := make([]byte, ChunkSize)
+			n, err := openChunk(keys, id, out, metadata)
+			if err != nil {
+				t.Error("expected no errors; got", err)
+			}
+			if !bytes.Equal(out[:n], data) {
+				t.Error("opened chunk does not match")
+			}
+
+			// It does not open as the other type.
+			if _, err := openChunk(keys, id, out, !metadata); err != ErrDecryptionFailed {
+				t.Error("expected ErrDecryptionFailed; got", err)
+			}
+		}
+	}
+
+	// Buffers of the wrong size are rejected.
+	if err := sealChunk(keys, id, make([]byte, ChunkSize-1), 0, false, false); err != ErrInvalidChunk {
+		t.Error("expected ErrInvalidChunk; got", err)
+	}
+	if _, err := openChunk(keys, id, make([]byte, ChunkSize+1), false); err != ErrInvalidChunk {
+		t.Error("expected ErrInvalidChunk; got", err)
+	}
+
+	// Missing chunks are reported as such.
+	memguard.ScrambleBytes(id)
+	if _, err := openChunk(keys, id, make([]byte, ChunkSize), false); err != ErrEntryNotFound {
+		t.Error("expected ErrEntryNotFound; got", err)
+	}
+}
+
+func TestOpenChunkLegacy(t *testing.T) {
+	keys := testChunkKeys(t)
+	defer keys.Destroy()
+
+	data := []byte("legacy chunk data")
+	chunk := make([]byte, ChunkSize)
+	copy(chunk, data)
+	if err := Pad(chunk, len(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks sealed under the pocket key bound to their identifier.
+	bound := make([]byte, 32)
+	memguard.ScrambleBytes(bound)
+	if err := Seal(bound, chunk, keys.pocket.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks sealed under the pocket key itself.
+	unbound := make([]byte, 32)
+	memguard.ScrambleBytes(unbound)
+	ct, err := Encrypt(chunk, keys.pocket.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Put(unbound, ct); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range [][]byte{bound, unbound} {
+		for _, metadata := range []bool{true, false} {
+			out := make([]byte, ChunkSize)
+			n, err := openChunk(keys, id, out, metadata)
+			if err != nil {
+				t.Error("expected no errors; got", err)
+			}
+			if !bytes.Equal(out[:n], data) {
+				t.Error("opened chunk does not match")
+			}
+		}
+	}
+
+	// A legacy chunk that does not hold a single padded chunk is rejected.
+	short := make([]byte, 32)
+	memguard.ScrambleBytes(short)
+	if ct, err = Encrypt(chunk[:ChunkSize-1], keys.pocket.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Put(short, ct); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openChunk(keys, short, make([]byte, ChunkSize), false); err != ErrInvalidChunk {
+		t.Error("expected ErrInvalidChunk; got", err)
+	}
+}
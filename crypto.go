@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"strings"
 	"unsafe"
 
+	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/nacl/secretbox"
 
 	"github.com/awnumar/memguard"
@@ -12,6 +17,9 @@ import (
 // Overhead is the size by which the ciphertext exceeds the plaintext.
 const Overhead int = secretbox.Overhead + 24 // auth + nonce
 
+// randomBytes fills a buffer with cryptographically-secure random bytes. All randomness used by this package must be sourced through it.
+var randomBytes = memguard.ScrambleBytes
+
 // ErrInvalidKeyLength is returned when attempting to encrypt or decrypt with a key that is not exactly 32 bytes in size.
 var ErrInvalidKeyLength = errors.New("<gravity::core::ErrInvalidKeyLength> key must be exactly 32 bytes")
 
@@ -21,6 +29,12 @@ var ErrBufferTooSmall = errors.New("<gravity::core::ErrBufferTooSmall> the given
 // ErrDecryptionFailed is returned when the attempted decryption fails. This can occur if the given key is incorrect or if the ciphertext is invalid.
 var ErrDecryptionFailed = errors.New("<gravity::core::ErrDecryptionFailed> decryption failed")
 
+// ErrWeakKey is returned when attempting to encrypt with a key that is all zeros. A properly derived key will in practice never take this value, so seeing it indicates a bug.
+var ErrWeakKey = errors.New("<gravity::core::ErrWeakKey> refusing to encrypt with an all-zero key")
+
+// RejectWeakKeys specifies whether the encryption functions refuse weak keys. It should only be disabled by tests that intentionally use fixed keys.
+var RejectWeakKeys = true
+
 // Encrypt takes a plaintext message and a 32 byte key and returns an authenticated ciphertext.
 func Encrypt(plaintext, key []byte) ([]byte, error) {
 	// Check the length of the key is correct.
@@ -28,12 +42,17 @@ func Encrypt(plaintext, key []byte) ([]byte, error) {
 		return nil, ErrInvalidKeyLength
 	}
 
+	// Check the key is not trivially weak.
+	if weakKey(key) {
+		return nil, ErrWeakKey
+	}
+
 	// Get a reference to the key's underlying array without making a copy.
 	k := (*[32]byte)(unsafe.Pointer(&key[0]))
 
 	// Allocate space for and generate a nonce value.
 	var nonce [24]byte
-	memguard.ScrambleBytes(nonce[:])
+	randomBytes(nonce[:])
 
 	// Encrypt m and return the result.
 	return secretbox.Seal(nonce[:], plaintext, &nonce, k), nil
@@ -52,6 +71,11 @@ func Decrypt(ciphertext, key []byte, output []byte) (int, error) {
 		return 0, ErrInvalidKeyLength
 	}
 
+	// Check the ciphertext is large enough to contain a nonce and authenticator.
+	if len(ciphertext) < Overhead {
+		return 0, ErrDecryptionFailed
+	}
+
 	// Check the capacity of the given output buffer.
 	if cap(output) < (len(ciphertext) - Overhead) {
 		return 0, ErrBufferTooSmall
@@ -75,3 +99,121 @@ func Decrypt(ciphertext, key []byte, output []byte) (int, error) {
 	// Decryption unsuccessful. Either the key was wrong or the authentication failed.
 	return 0, ErrDecryptionFailed
 }
+
+// weakKey reports whether a given key is all zeros and RejectWeakKeys is set. It runs in constant time with respect to the key's contents.
+func weakKey(key []byte) bool {
+	var acc byte
+	for i := range key {
+		acc |= key[i]
+	}
+	return RejectWeakKeys && acc == 0
+}
+
+/*
+BindKey derives a key that is unique to a given storage identifier from a 32 byte key.
+
+Ciphertexts sealed under a bound key will only decrypt under the same (key, identifier) pair, so an entry that is moved to a different slot in the database will fail authentication. The caller is responsible for destroying the returned buffer.
+*/
+func BindKey(key, id []byte) (*memguard.LockedBuffer, error) {
+	// Check the length of the key is correct.
+	if len(key) != 32 {
+		return nil, ErrInvalidKeyLength
+	}
+
+	// Compute a keyed hash of the identifier. This cannot fail as the key is at most 64 bytes.
+	h, _ := blake2b.New256(key)
+	h.Write(id)
+
+	// Move the result into a secure buffer.
+	return memguard.NewBufferFromBytes(h.Sum(nil)), nil
+}
+
+// PEMType is the type label of PEM blocks produced by EncryptPEM.
+const PEMType = "GRAVITY CIPHERTEXT"
+
+// EncryptPEM encrypts a plaintext message with a 32 byte key and returns the ciphertext encoded as a PEM block, wrapped at 64 columns.
+func EncryptPEM(plaintext, key []byte) ([]byte, error) {
+	ct, err := Encrypt(plaintext, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEMType, Bytes: ct}), nil
+}
+
+// ErrMalformedPEM is returned when a PEMType block is found that cannot be parsed.
+var ErrMalformedPEM = errors.New("<gravity::core::ErrMalformedPEM> found a ciphertext block that could not be parsed")
+
+/*
+DecryptAllPEM finds every PEM block of type PEMType within the given data and decrypts each of them with a 32 byte key. Any text surrounding or between the blocks, and blocks of other types, are ignored. Leading and trailing whitespace on each line, and blank lines, are tolerated anywhere.
+
+The plaintexts are returned in secure buffers in the order they appear in the data. If any PEMType block cannot be parsed or fails to decrypt, an error is returned and no plaintexts are.
+*/
+func DecryptAllPEM(data, key []byte) ([]*memguard.LockedBuffer, error) {
+	// Strip whitespace from each line and drop blank lines, which would otherwise end a block's headers early.
+	var normalised []byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) != 0 {
+			normalised = append(append(normalised, line...), '\n')
+		}
+	}
+	markers := bytes.Count(normalised, []byte("-----BEGIN "+PEMType+"-----"))
+
+	var plaintexts []*memguard.LockedBuffer
+	fail := func(err error) ([]*memguard.LockedBuffer, error) {
+		for _, p := range plaintexts {
+			p.Destroy()
+		}
+		return nil, err
+	}
+	for rest := normalised; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != PEMType {
+			continue
+		}
+
+		// Decrypt the block directly into a secure buffer.
+		b := memguard.NewBuffer(len(block.Bytes) - Overhead)
+		if _, err := Decrypt(block.Bytes, key, b.Bytes()); err != nil {
+			b.Destroy()
+			return fail(err)
+		}
+		b.Freeze()
+		plaintexts = append(plaintexts, b)
+	}
+
+	// pem.Decode skips blocks it cannot parse, so make sure none were lost.
+	if len(plaintexts) != markers {
+		return fail(ErrMalformedPEM)
+	}
+	return plaintexts, nil
+}
+
+// verificationCodeKey domain-separates verification codes from other uses of BLAKE2b on the same data.
+var verificationCodeKey = []byte("gravity verification code")
+
+/*
+VerificationCode returns a short human-readable code derived from a secret, formatted as six groups of five digits. Two parties can read their codes aloud to confirm that they hold the same secret without revealing it.
+
+The code is computed from a keyed BLAKE2b hash of the secret and so cannot be reversed, but a low-entropy secret can still be guessed by trying candidates against its code.
+*/
+func VerificationCode(secret []byte) string {
+	// Hash the secret. This cannot fail as the key is less than 64 bytes.
+	h, _ := blake2b.New256(verificationCodeKey)
+	h.Write(secret)
+	sum := h.Sum(nil)
+
+	// Encode each five byte block of the hash as five decimal digits.
+	groups := make([]string, 6)
+	for i := range groups {
+		var v uint64
+		for _, b := range sum[i*5 : i*5+5] {
+			v = v<<8 | uint64(b)
+		}
+		groups[i] = fmt.Sprintf("%05d", v%100000)
+	}
+	return strings.Join(groups, " ")
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/pem"
+	"strings"
 	"testing"
 
 	"github.com/awnumar/memguard"
@@ -113,3 +115,289 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Error("expected error with invalid key; got", err)
 	}
 }
+
+func TestBindKey(t *testing.T) {
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+
+	// Bind the key to two distinct identifiers.
+	a, err := BindKey(k, []byte("a"))
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	defer a.Destroy()
+	b, err := BindKey(k, []byte("b"))
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	defer b.Destroy()
+	if a.Size() != 32 || b.Size() != 32 {
+		t.Error("unexpected bound key size")
+	}
+	if a.EqualTo(b.Bytes()) {
+		t.Error("bound keys for distinct identifiers are equal")
+	}
+
+	// Binding is deterministic.
+	c, err := BindKey(k, []byte("a"))
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	defer c.Destroy()
+	if !a.EqualTo(c.Bytes()) {
+		t.Error("bound keys for the same identifier differ")
+	}
+
+	// Attempt binding with a key of an invalid length.
+	ik, err := BindKey(make([]byte, 16), []byte("a"))
+	if err != ErrInvalidKeyLength {
+		t.Error("expected error with invalid key; got", err)
+	}
+	if ik != nil {
+		t.Error("expected nil key; got", ik)
+	}
+}
+
+func TestDecryptShortCiphertext(t *testing.T) {
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+
+	for _, size := range []int{0, 1, 24, Overhead - 1} {
+		length, err := Decrypt(make([]byte, size), k, make([]byte, 64))
+		if err != ErrDecryptionFailed {
+			t.Error("expected decryption failure for short ciphertext; got", err)
+		}
+		if length != 0 {
+			t.Error("expected length = 0; got", length)
+		}
+	}
+}
+
+func TestEncryptDecryptPEM(t *testing.T) {
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+
+	// Encrypt some messages, one larger than a single PEM line.
+	messages := [][]byte{[]byte("first secret"), make([]byte, 256), {}}
+	memguard.ScrambleBytes(messages[1])
+	var blocks [][]byte
+	for _, m := range messages {
+		b, err := EncryptPEM(m, k)
+		if err != nil {
+			t.Error("expected no errors; got", err)
+		}
+		blocks = append(blocks, b)
+	}
+
+	// Check the block format.
+	if !bytes.HasPrefix(blocks[0], []byte("-----BEGIN "+PEMType+"-----\n")) {
+		t.Error("unexpected block header:", string(blocks[0]))
+	}
+	for _, line := range bytes.Split(blocks[1], []byte("\n")) {
+		if len(line) > 64 && !bytes.HasPrefix(line, []byte("-----")) {
+			t.Error("line exceeds 64 columns:", string(line))
+		}
+	}
+
+	// Construct a file with the blocks surrounded by noise and an unrelated block.
+	var file bytes.Buffer
+	file.WriteString("some notes at the top of the file\n\n")
+	file.Write(blocks[0])
+	file.WriteString("\n   \t\n-----BEGIN OTHER-----\nAAAA\n-----END OTHER-----\nmore noise\n")
+	file.Write(blocks[1])
+	file.Write(blocks[2])
+	file.WriteString("trailing noise")
+
+	// Decrypt every block.
+	plaintexts, err := DecryptAllPEM(file.Bytes(), k)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if len(plaintexts) != len(messages) {
+		t.Fatal("unexpected number of plaintexts; got", len(plaintexts))
+	}
+	for i := range messages {
+		if !bytes.Equal(plaintexts[i].Bytes(), messages[i]) {
+			t.Error("decrypted plaintext does not match original")
+		}
+		plaintexts[i].Destroy()
+	}
+
+	// Data without any blocks yields nothing.
+	plaintexts, err = DecryptAllPEM([]byte("no blocks here"), k)
+	if err != nil || len(plaintexts) != 0 {
+		t.Error("expected no plaintexts; got", len(plaintexts), err)
+	}
+
+	// A wrong key fails the whole operation.
+	ik := make([]byte, 32)
+	memguard.ScrambleBytes(ik)
+	plaintexts, err = DecryptAllPEM(file.Bytes(), ik)
+	if err != ErrDecryptionFailed || plaintexts != nil {
+		t.Error("expected error with incorrect key; got", err)
+	}
+
+	// Indented blocks and blocks containing blank or whitespace-only lines are still found.
+	var messy bytes.Buffer
+	for i, line := range bytes.Split(bytes.TrimSpace(blocks[1]), []byte("\n")) {
+		messy.WriteString("  ")
+		messy.Write(line)
+		messy.WriteString(" \r\n")
+		if i == 2 {
+			messy.WriteString("\n \t \n")
+		}
+	}
+	plaintexts, err = DecryptAllPEM(messy.Bytes(), k)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if len(plaintexts) != 1 || !bytes.Equal(plaintexts[0].Bytes(), messages[1]) {
+		t.Error("failed to recover block with irregular whitespace; got", len(plaintexts), "plaintexts")
+	}
+	for _, p := range plaintexts {
+		p.Destroy()
+	}
+
+	// A block that cannot be parsed is reported rather than skipped.
+	broken := append([]byte{}, blocks[0]...)
+	broken = bytes.Replace(broken, []byte("-----END "+PEMType+"-----"), []byte("-----END SOMETHING-----"), 1)
+	broken = append(broken, blocks[1]...)
+	plaintexts, err = DecryptAllPEM(broken, k)
+	if err != ErrMalformedPEM || plaintexts != nil {
+		t.Error("expected ErrMalformedPEM; got", len(plaintexts), err)
+	}
+
+	// So does a truncated block.
+	short, _ := pem.Decode(blocks[0])
+	short.Bytes = short.Bytes[:Overhead-1]
+	plaintexts, err = DecryptAllPEM(pem.EncodeToMemory(short), k)
+	if err != ErrDecryptionFailed || plaintexts != nil {
+		t.Error("expected error with truncated block; got", err)
+	}
+}
+
+func TestWeakKey(t *testing.T) {
+	m := []byte("some secret data")
+	zero := make([]byte, 32)
+
+	// An all-zero key is rejected by both encryption functions.
+	if x, err := Encrypt(m, zero); err != ErrWeakKey || x != nil {
+		t.Error("expected ErrWeakKey; got", err)
+	}
+	if x, err := EncryptCascade(m, zero); err != ErrWeakKey || x != nil {
+		t.Error("expected ErrWeakKey; got", err)
+	}
+
+	// A key with a single bit set is accepted.
+	k := make([]byte, 32)
+	k[31] = 0x80
+	if _, err := Encrypt(m, k); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+
+	// The guard can be disabled.
+	RejectWeakKeys = false
+	defer func() { RejectWeakKeys = true }()
+	x, err := Encrypt(m, zero)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	dm := make([]byte, len(x)-Overhead)
+	if _, err := Decrypt(x, zero, dm); err != nil || !bytes.Equal(m, dm) {
+		t.Error("failed to decrypt under fixed key; got", err)
+	}
+}
+
+func TestVerificationCode(t *testing.T) {
+	secret := make([]byte, 32)
+	memguard.ScrambleBytes(secret)
+
+	// Check the format.
+	code := VerificationCode(secret)
+	groups := strings.Split(code, " ")
+	if len(groups) != 6 {
+		t.Error("unexpected number of groups:", code)
+	}
+	for _, g := range groups {
+		if len(g) != 5 || strings.Trim(g, "0123456789") != "" {
+			t.Error("invalid group in code:", code)
+		}
+	}
+
+	// Identical secrets give identical codes.
+	if VerificationCode(append([]byte{}, secret...)) != code {
+		t.Error("codes for identical secrets differ")
+	}
+
+	// A single bit flip should change most of the code.
+	for i := 0; i < 16; i++ {
+		other := append([]byte{}, secret...)
+		other[i] ^= 0x01
+		otherCode := VerificationCode(other)
+		if otherCode == code {
+			t.Error("codes for different secrets are equal")
+		}
+		same := 0
+		for j := range code {
+			if code[j] == otherCode[j] && code[j] != ' ' {
+				same++
+			}
+		}
+		if same > 15 {
+			t.Error("codes for similar secrets are too similar:", code, otherCode)
+		}
+	}
+
+	// The code does not contain the secret itself.
+	if strings.Contains(VerificationCode([]byte("12345")), "12345") {
+		t.Error("code reveals the secret")
+	}
+
+	// The empty secret has a code too.
+	if len(VerificationCode(nil)) != 35 {
+		t.Error("unexpected code length for empty secret")
+	}
+}
+
+func TestDecryptBitFlips(t *testing.T) {
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+
+	// Use a padded chunk, as stored by the seal command.
+	m := make([]byte, 4096)
+	memguard.ScrambleBytes(m[:100])
+	if err := Pad(m, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]struct {
+		encrypt  func([]byte, []byte) ([]byte, error)
+		decrypt  func([]byte, []byte, []byte) (int, error)
+		overhead int
+	}{
+		"Decrypt":        {Encrypt, Decrypt, Overhead},
+		"DecryptCascade": {EncryptCascade, DecryptCascade, CascadeOverhead},
+	} {
+		x, err := c.encrypt(m, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, len(x)-c.overhead)
+
+		// The unmodified ciphertext decrypts.
+		if _, err := c.decrypt(x, k, out); err != nil || !bytes.Equal(out, m) {
+			t.Fatal(name, "failed on unmodified ciphertext:", err)
+		}
+
+		// Every single bit flip is detected.
+		for i := range x {
+			for bit := uint(0); bit < 8; bit++ {
+				x[i] ^= 1 << bit
+				if length, err := c.decrypt(x, k, out); err != ErrDecryptionFailed || length != 0 {
+					t.Fatal(name, "accepted ciphertext with bit", bit, "of byte", i, "flipped; got", err)
+				}
+				x[i] ^= 1 << bit
+			}
+		}
+	}
+}
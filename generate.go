@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/awnumar/memguard"
+)
+
+// ErrInvalidPolicy is returned when a secret cannot be generated that satisfies the given length and character set policy.
+var ErrInvalidPolicy = errors.New("<gravity::core::ErrInvalidPolicy> policy cannot be satisfied")
+
+// Character classes used by CharsetPolicy.
+const (
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	digitChars  = "0123456789"
+	symbolChars = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+/*
+CharsetPolicy specifies the character classes that a generated secret is made up of.
+
+Each class field gives the minimum number of characters of that class that must appear. A value of zero allows the class without requiring it, and a negative value excludes the class entirely. Characters in Exclude never appear in the output.
+*/
+type CharsetPolicy struct {
+	Upper   int
+	Lower   int
+	Digit   int
+	Symbol  int
+	Exclude string
+}
+
+// GenerateSecret generates a random secret of the given length that satisfies a given policy. The secret is returned in an immutable secure buffer.
+func GenerateSecret(length int, policy CharsetPolicy) (*memguard.LockedBuffer, error) {
+	// Filter each class against the excluded set and collect the ones in use.
+	var classes []string
+	var mins []int
+	required := 0
+	for _, c := range []struct {
+		chars string
+		min   int
+	}{
+		{upperChars, policy.Upper},
+		{lowerChars, policy.Lower},
+		{digitChars, policy.Digit},
+		{symbolChars, policy.Symbol},
+	} {
+		if c.min < 0 {
+			continue
+		}
+		class := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(policy.Exclude, r) {
+				return -1
+			}
+			return r
+		}, c.chars)
+		if len(class) == 0 {
+			if c.min > 0 {
+				return nil, ErrInvalidPolicy
+			}
+			continue
+		}
+		classes = append(classes, class)
+		mins = append(mins, c.min)
+		required += c.min
+	}
+	if length <= 0 || len(classes) == 0 || required > length {
+		return nil, ErrInvalidPolicy
+	}
+	charset := strings.Join(classes, "")
+
+	// Fill the required characters of each class, then the remainder from the whole set.
+	b := memguard.NewBuffer(length)
+	out := b.Bytes()
+	i := 0
+	for k := range classes {
+		for j := 0; j < mins[k]; j++ {
+			out[i] = classes[k][randomIndex(len(classes[k]))]
+			i++
+		}
+	}
+	for ; i < length; i++ {
+		out[i] = charset[randomIndex(len(charset))]
+	}
+
+	// Shuffle so that the required characters are not at predictable positions.
+	for i := length - 1; i > 0; i-- {
+		j := randomIndex(i + 1)
+		out[i], out[j] = out[j], out[i]
+	}
+
+	b.Freeze()
+	return b, nil
+}
+
+// randomIndex returns a uniformly random integer in [0, n). Rejection sampling is used to avoid modulo bias.
+func randomIndex(n int) int {
+	// Values at or above limit would over-represent the low residues.
+	r := uint32((uint64(math.MaxUint32) + 1) % uint64(n))
+	limit := uint32(math.MaxUint32) - r
+
+	var buf [4]byte
+	defer memguard.WipeBytes(buf[:])
+	for {
+		randomBytes(buf[:])
+		if v := binary.LittleEndian.Uint32(buf[:]); v <= limit {
+			return int(v % uint32(n))
+		}
+	}
+}
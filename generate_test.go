@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSecret(t *testing.T) {
+	policy := CharsetPolicy{Upper: 2, Lower: 2, Digit: 3, Symbol: 1, Exclude: "O0Il1"}
+	for i := 0; i < 256; i++ {
+		s, err := GenerateSecret(12, policy)
+		if err != nil {
+			t.Fatal("expected no errors; got", err)
+		}
+		if s.Size() != 12 {
+			t.Error("unexpected length; got", s.Size())
+		}
+		if s.IsMutable() {
+			t.Error("secret is mutable")
+		}
+
+		// Count each class and check the exclusions.
+		var upper, lower, digit, symbol int
+		for _, c := range s.Bytes() {
+			switch {
+			case strings.IndexByte(policy.Exclude, c) != -1:
+				t.Error("excluded character in output:", string(c))
+			case strings.IndexByte(upperChars, c) != -1:
+				upper++
+			case strings.IndexByte(lowerChars, c) != -1:
+				lower++
+			case strings.IndexByte(digitChars, c) != -1:
+				digit++
+			case strings.IndexByte(symbolChars, c) != -1:
+				symbol++
+			default:
+				t.Error("unexpected character in output:", string(c))
+			}
+		}
+		if upper < 2 || lower < 2 || digit < 3 || symbol < 1 {
+			t.Error("policy not satisfied:", string(s.Bytes()))
+		}
+		s.Destroy()
+	}
+
+	// Excluded classes never appear.
+	s, err := GenerateSecret(64, CharsetPolicy{Upper: -1, Lower: -1, Symbol: -1})
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	if len(bytes.Trim(s.Bytes(), digitChars)) != 0 {
+		t.Error("unexpected character in digit-only output:", string(s.Bytes()))
+	}
+	s.Destroy()
+
+	// Unsatisfiable policies are rejected.
+	for _, c := range []struct {
+		length int
+		policy CharsetPolicy
+	}{
+		{0, CharsetPolicy{}},
+		{4, CharsetPolicy{Upper: 3, Lower: 2}},
+		{4, CharsetPolicy{Upper: -1, Lower: -1, Digit: -1, Symbol: -1}},
+		{4, CharsetPolicy{Digit: 1, Exclude: digitChars}},
+		{4, CharsetPolicy{Upper: -1, Lower: -1, Symbol: -1, Exclude: digitChars}},
+	} {
+		if s, err := GenerateSecret(c.length, c.policy); err != ErrInvalidPolicy || s != nil {
+			t.Error("expected ErrInvalidPolicy; got", err)
+		}
+	}
+}
+
+func TestRandomIndexRejection(t *testing.T) {
+	// Stub the random source to return a fixed sequence of 32-bit values.
+	var values []uint32
+	var draws int
+	source := randomBytes
+	randomBytes = func(buf []byte) {
+		binary.LittleEndian.PutUint32(buf, values[draws])
+		draws++
+	}
+	defer func() { randomBytes = source }()
+
+	// For n = 94, 2^32 mod n = 42, so the top 42 values must be rejected.
+	const n = 94
+	const limit = math.MaxUint32 - 42
+
+	// A value above the limit is rejected and the next draw is used. A plain v % n would return 41 here.
+	values, draws = []uint32{math.MaxUint32, 95}, 0
+	if i := randomIndex(n); i != 1 || draws != 2 {
+		t.Error("expected out-of-range draw to be rejected; got", i, "after", draws, "draws")
+	}
+	values, draws = []uint32{limit + 1, limit + 2, 3}, 0
+	if i := randomIndex(n); i != 3 || draws != 3 {
+		t.Error("expected out-of-range draws to be rejected; got", i, "after", draws, "draws")
+	}
+
+	// The limit itself is the largest accepted value.
+	values, draws = []uint32{limit}, 0
+	if i := randomIndex(n); i != limit%n || draws != 1 {
+		t.Error("expected limit to be accepted; got", i, "after", draws, "draws")
+	}
+
+	// Powers of two divide 2^32, so nothing is rejected.
+	values, draws = []uint32{math.MaxUint32}, 0
+	if i := randomIndex(64); i != 63 || draws != 1 {
+		t.Error("expected draw to be accepted for power of two; got", i, "after", draws, "draws")
+	}
+}
+
+func TestRandomIndexDistribution(t *testing.T) {
+	// A coarse uniformity check against the real source. This catches gross errors such as an off-by-one in the range, not the tiny bias that rejection sampling removes.
+	const n = 94
+	const samples = 94000
+	var counts [n]int
+	for i := 0; i < samples; i++ {
+		counts[randomIndex(n)]++
+	}
+
+	// The test statistic has n-1 degrees of freedom.
+	expected := float64(samples) / n
+	var chi float64
+	for _, c := range counts {
+		chi += math.Pow(float64(c)-expected, 2) / expected
+	}
+	if chi > 160 { // p < 0.0001 for 93 degrees of freedom
+		t.Error("output distribution is not uniform; chi-squared", chi)
+	}
+}
@@ -13,6 +13,21 @@ func input(prompt string) *memguard.LockedBuffer {
 	return memguard.NewBufferFromReaderUntil(os.Stdin, '\n')
 }
 
+// masterKey reads the master key from standard input. If split is set, two key components are read separately and combined, so that neither holder can unlock the pocket alone.
+func masterKey(split bool) *memguard.LockedBuffer {
+	if !split {
+		return input("[?] Enter master key: ")
+	}
+	a := input("[?] Enter key component A: ")
+	b := input("[?] Enter key component B: ")
+	if a.Size() == 0 || b.Size() == 0 {
+		a.Destroy()
+		b.Destroy()
+		return memguard.NewBuffer(0) // Rejected by GetPocket like any other empty key.
+	}
+	return CombineKeys(a, b)
+}
+
 func prompt() string {
 	stdin := bufio.NewReader(os.Stdin)
 	i, err := stdin.ReadString('\n')
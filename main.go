@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/awnumar/memguard"
 	"github.com/docker/go-units"
@@ -15,9 +19,19 @@ import (
 var args = os.Args
 
 func main() {
+	// Open disk-backed database, for the commands that use it.
+	if len(args) > 1 && (args[1] == "seal" || args[1] == "open" || args[1] == "wipe") {
+		if err := openDB(); err != nil {
+			outputError(err)
+			return
+		}
+	}
+
 	cleanup := func() {
 		// Sync and close disk-backed database.
-		closeDB()
+		if database != nil {
+			closeDB()
+		}
 
 		// Purge sensitive information from memory.
 		memguard.Purge()
@@ -28,6 +42,25 @@ func main() {
 	})
 	defer cleanup() // Run cleanup after returning.
 
+	// Parse options, which come between the command and its arguments.
+	var split, cascade bool
+	for len(args) > 2 && strings.HasPrefix(args[2], "-") {
+		switch args[2] {
+		case "-split":
+			split = true // The key is split between two operators.
+		case "-cascade":
+			cascade = true // Seal with two independent ciphers.
+		default:
+			help()
+			return
+		}
+		args = append([]string{args[0], args[1]}, args[3:]...)
+	}
+	if cascade && args[1] != "seal" {
+		help()
+		return
+	}
+
 	// Parse command line arguments.
 	if args[1] == "seal" {
 		if len(args) != 3 {
@@ -51,11 +84,15 @@ func main() {
 		fmt.Printf("[i] Encrypting %d files from \"%s\" (%s)\n", len(files), args[2], units.BytesSize(float64(totalSize)))
 
 		// Read key from standard input directly into secure buffer.
-		key := input("[?] Enter master key: ")
+		key := masterKey(split)
 
 		// Derive root key from user key.
 		fmt.Println("[i] Processing key...")
-		pocket := GetPocket(key)
+		pocket, err := GetPocket(key)
+		if err != nil {
+			outputError(err)
+			return
+		}
 
 		// Initialise identifier.
 		id, idMemory, err := pocket.Identifier()
@@ -64,15 +101,16 @@ func main() {
 			return
 		}
 
-		// Get local copy of key
-		key, err = pocket.Key.Open()
+		// Derive separate keys for metadata and contents
+		keys, err := pocket.ChunkKeys()
 		if err != nil {
 			outputError(err)
 			return
 		}
+		defer keys.Destroy()
 
 		// Process each file
-		var buffer [4096]byte
+		var buffer [ChunkSize]byte
 		for file, fileInfo := range files {
 			fmt.Printf("[+] Sealing %s (%s)\n", fileInfo.Path, units.BytesSize(float64(fileInfo.Size)))
 
@@ -89,10 +127,7 @@ func main() {
 				} else {
 					size = copy(buffer[:], metadata[i:i+4095])
 				}
-				buffer[size] = 1 // padding
-
-				ct, _ := Encrypt(buffer[:], key.Bytes())
-				if err := Put(id.Derive(idMemory, uint64(file), uint64(2*i/4095+1)), ct); err != nil {
+				if err := sealChunk(keys, id.Derive(idMemory, uint64(file), uint64(2*i/4095+1)), buffer[:], size, true, cascade); err != nil {
 					outputError(err)
 					return
 				}
@@ -110,9 +145,7 @@ func main() {
 				if n == 0 {
 					break
 				}
-				buffer[n] = 1 // padding
-				ct, _ := Encrypt(buffer[:], key.Bytes())
-				if err := Put(id.Derive(idMemory, uint64(file), c), ct); err != nil {
+				if err := sealChunk(keys, id.Derive(idMemory, uint64(file), c), buffer[:], n, false, cascade); err != nil {
 					outputError(err)
 					return
 				}
@@ -137,11 +170,15 @@ func main() {
 		}
 
 		// Read key from standard input directly into secure buffer.
-		key := input("[?] Enter master key: ")
+		key := masterKey(split)
 
 		// Derive root key from user key.
 		fmt.Println("[i] Processing key...")
-		pocket := GetPocket(key)
+		pocket, err := GetPocket(key)
+		if err != nil {
+			outputError(err)
+			return
+		}
 
 		// Initialise identifier.
 		id, idMemory, err := pocket.Identifier()
@@ -150,44 +187,29 @@ func main() {
 			return
 		}
 
-		// Get local copy of key
-		key, err = pocket.Key.Open()
+		// Derive separate keys for metadata and contents
+		keys, err := pocket.ChunkKeys()
 		if err != nil {
 			outputError(err)
 			return
 		}
+		defer keys.Destroy()
 
 		// Extract data
-		var buffer [4096]byte
+		var buffer [ChunkSize]byte
 		for i := uint64(0); ; i++ { // for every file...
 			// Handle metadata
 			var metadata []byte
 			for j := uint64(1); ; j += 2 {
-				chunk, err := Get(id.Derive(idMemory, i, j))
-				if err != nil {
+				k, err := openChunk(keys, id.Derive(idMemory, i, j), buffer[:], true)
+				if err == ErrEntryNotFound {
 					// eof
 					break
-				}
-				n, err := Decrypt(chunk, key.Bytes(), buffer[:])
-				if err != nil {
+				} else if err != nil {
 					outputError(err)
 					return
 				}
-				if n != 4096 {
-					outputError(errors.New("error invalid plaintext size"))
-					return
-				}
-				for k := len(buffer) - 1; ; k-- {
-					if buffer[k] == 0 {
-						continue
-					} else if buffer[k] == 1 {
-						metadata = append(metadata, buffer[:k]...)
-						break
-					} else {
-						outputError(errors.New("error invalid padding"))
-						return
-					}
-				}
+				metadata = append(metadata, buffer[:k]...)
 				memguard.WipeBytes(buffer[:])
 			}
 			if len(metadata) == 0 {
@@ -218,38 +240,157 @@ func main() {
 
 			// Handle contents
 			for j := uint64(0); ; j += 2 {
-				chunk, err := Get(id.Derive(idMemory, i, j))
-				if err != nil {
+				k, err := openChunk(keys, id.Derive(idMemory, i, j), buffer[:], false)
+				if err == ErrEntryNotFound {
 					// eof
 					break
-				}
-				n, err := Decrypt(chunk, key.Bytes(), buffer[:])
-				if err != nil {
+				} else if err != nil {
 					outputError(err)
 					return
 				}
-				if n != 4096 {
-					outputError(errors.New("error invalid plaintext size"))
+				if _, err := file.Write(buffer[:k]); err != nil {
+					outputError(err)
 					return
 				}
-				for k := len(buffer) - 1; ; k-- {
-					if buffer[k] == 0 {
-						continue
-					} else if buffer[k] == 1 {
-						if _, err := file.Write(buffer[:k]); err != nil {
-							outputError(err)
-							return
-						}
-						break
-					} else {
-						outputError(errors.New("error invalid padding"))
-						return
-					}
-				}
 			}
 			file.Close()
 		}
 		return
+	} else if args[1] == "armor" || args[1] == "unarmor" {
+		if len(args) != 3 {
+			goto help
+		}
+
+		// Work out where the output goes.
+		in, out := args[2], args[2]+".pem"
+		if args[1] == "unarmor" {
+			if !strings.HasSuffix(in, ".pem") {
+				outputError(errors.New("error file name does not end in .pem"))
+				return
+			}
+			out = strings.TrimSuffix(in, ".pem")
+		}
+		data, err := ioutil.ReadFile(in)
+		if err != nil {
+			outputError(err)
+			return
+		}
+
+		// Read key from standard input directly into secure buffer.
+		key := masterKey(split)
+
+		// Derive root key from user key.
+		fmt.Println("[i] Processing key...")
+		pocket, err := GetPocket(key)
+		if err != nil {
+			outputError(err)
+			return
+		}
+		armorKey, err := pocket.ArmorKey()
+		if err != nil {
+			outputError(err)
+			return
+		}
+		defer armorKey.Destroy()
+
+		// Encrypt or decrypt the file.
+		var blocks [][]byte
+		if args[1] == "armor" {
+			fmt.Printf("[+] Armoring %s to %s\n", in, out)
+			block, err := EncryptPEM(data, armorKey.Bytes())
+			if err != nil {
+				outputError(err)
+				return
+			}
+			blocks = append(blocks, block)
+		} else {
+			fmt.Printf("[+] Unarmoring %s to %s\n", in, out)
+			plaintexts, err := DecryptAllPEM(data, armorKey.Bytes())
+			if err != nil {
+				outputError(err)
+				return
+			}
+			for _, p := range plaintexts {
+				defer p.Destroy()
+				blocks = append(blocks, p.Bytes())
+			}
+		}
+
+		// Write the result, refusing to overwrite anything.
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			outputError(err)
+			return
+		}
+		defer f.Close()
+		for _, b := range blocks {
+			if _, err := f.Write(b); err != nil {
+				outputError(err)
+				return
+			}
+		}
+		return
+	} else if args[1] == "code" {
+		if len(args) != 2 {
+			goto help
+		}
+
+		// Read key from standard input directly into secure buffer.
+		key := masterKey(split)
+
+		// Derive root key from user key.
+		fmt.Println("[i] Processing key...")
+		pocket, err := GetPocket(key)
+		if err != nil {
+			outputError(err)
+			return
+		}
+
+		// The code is computed from the pocket key rather than the master key, so that guessing the master key from the code costs a key derivation per guess.
+		root, err := pocket.Key.Open()
+		if err != nil {
+			outputError(err)
+			return
+		}
+		defer root.Destroy()
+		fmt.Println("[i] Verification code:", VerificationCode(root.Bytes()))
+		return
+	} else if args[1] == "generate" {
+		if len(args) != 3 {
+			goto help
+		}
+		length, err := strconv.Atoi(args[2])
+		if err != nil {
+			outputError(err)
+			return
+		}
+
+		// Require at least one character of each class.
+		secret, err := GenerateSecret(length, CharsetPolicy{Upper: 1, Lower: 1, Digit: 1, Symbol: 1})
+		if err != nil {
+			outputError(err)
+			return
+		}
+		defer secret.Destroy()
+
+		// Print the secret straight from the secure buffer.
+		os.Stdout.Write(secret.Bytes())
+		fmt.Println()
+		return
+	} else if args[1] == "bench" {
+		if len(args) != 2 {
+			goto help
+		}
+
+		// The cost of unlocking does not depend on the key, so no key is asked for.
+		fmt.Println("[i] Measuring unlock latency...")
+		latency, err := MeasureUnlockLatency([]byte("gravity benchmark"))
+		if err != nil {
+			outputError(err)
+			return
+		}
+		fmt.Printf("[i] Unlocking takes ~%s\n", latency.Round(10*time.Millisecond))
+		return
 	} else if args[1] == "wipe" {
 		if len(args) != 2 {
 			goto help
@@ -266,9 +407,18 @@ func help() {
 
 Commands:
 	help			print help information
-	seal {path}		encrypt and store data at given path
-	open {path}		decrypt and extract data and write to given path
+	seal [-split] [-cascade] {path}	encrypt and store data at given path
+	open [-split] {path}	decrypt and extract data and write to given path
+	armor [-split] {file}	encrypt a file to a PEM block written to {file}.pem
+	unarmor [-split] {file}.pem	decrypt every PEM block in a file and write the result to {file}
+	code [-split]		print a code that holders of the same key can compare out of band
+	generate {length}	print a random secret of the given length containing every character class
+	bench			measure how long unlocking takes with the current parameters
 	wipe			removes all data associated with an entry from the database
+
+Options:
+	-split			prompt for two key components held by separate operators
+	-cascade		seal with AES-256-GCM and then XChaCha20-Poly1305, at roughly twice the cost; open detects this by itself
 	`, args[0])
 }
 
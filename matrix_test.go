@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/awnumar/memguard"
+)
+
+// cipherPair adapts one of the package's encrypt/decrypt pairs to a common signature for TestMatrix. Add new algorithms here to have them covered.
+type cipherPair struct {
+	name    string
+	encrypt func(plaintext, key []byte) ([]byte, error)
+	decrypt func(ciphertext, key []byte) ([]byte, error)
+}
+
+var cipherPairs = []cipherPair{
+	{
+		"secretbox",
+		Encrypt,
+		func(ct, key []byte) ([]byte, error) {
+			out := make([]byte, len(ct)-Overhead)
+			_, err := Decrypt(ct, key, out)
+			return out, err
+		},
+	},
+	{
+		"cascade",
+		EncryptCascade,
+		func(ct, key []byte) ([]byte, error) {
+			out := make([]byte, len(ct)-CascadeOverhead)
+			_, err := DecryptCascade(ct, key, out)
+			return out, err
+		},
+	},
+	{
+		"pem",
+		EncryptPEM,
+		func(ct, key []byte) ([]byte, error) {
+			pts, err := DecryptAllPEM(ct, key)
+			if err != nil {
+				return nil, err
+			}
+			if len(pts) != 1 {
+				return nil, ErrMalformedPEM
+			}
+			defer pts[0].Destroy()
+			return append([]byte{}, pts[0].Bytes()...), nil
+		},
+	},
+	{
+		"store",
+		func(m, key []byte) ([]byte, error) {
+			if err := Seal([]byte("matrix test entry"), m, key); err != nil {
+				return nil, err
+			}
+			return Get([]byte("matrix test entry"))
+		},
+		func(ct, key []byte) ([]byte, error) {
+			if err := Put([]byte("matrix test entry"), ct); err != nil {
+				return nil, err
+			}
+			out := make([]byte, len(ct)-Overhead)
+			_, err := Open([]byte("matrix test entry"), key, out)
+			return out, err
+		},
+	},
+}
+
+// keySource produces a key for TestMatrix. Each call with the same seed must return the same key. Argon2 itself is left out for speed; its output is pinned by TestGetPocket.
+type keySource struct {
+	name   string
+	derive func(t *testing.T, seed []byte) []byte
+}
+
+// pocketKey derives a pocket from a seed using the given backend and returns its content subkey.
+func pocketKey(t *testing.T, backend KDF, seed []byte) []byte {
+	Backend = backend
+	defer func() { Backend = Argon2{} }()
+	pocket, err := GetPocket(memguard.NewBufferFromBytes(append([]byte{}, seed...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, content, err := pocket.Subkeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Destroy()
+	return append([]byte{}, content.Bytes()...)
+}
+
+var keySources = []keySource{
+	{"raw", func(t *testing.T, seed []byte) []byte {
+		k := blake2b.Sum256(seed)
+		return k[:]
+	}},
+	{"fastKDF", func(t *testing.T, seed []byte) []byte { return pocketKey(t, fastKDF{}, seed) }},
+}
+
+func TestMatrix(t *testing.T) {
+	for _, ks := range keySources {
+		ks := ks
+		t.Run(ks.name, func(t *testing.T) {
+			key := ks.derive(t, []byte("correct horse"))
+			wrong := ks.derive(t, []byte("battery staple"))
+			if bytes.Equal(key, wrong) {
+				t.Fatal("key source returned the same key for different seeds")
+			}
+
+			for _, c := range cipherPairs {
+				// Pad a message into a chunk, as the seal command does.
+				m := make([]byte, 4096)
+				memguard.ScrambleBytes(m[:1000])
+				if err := Pad(m, 1000); err != nil {
+					t.Fatal(err)
+				}
+
+				// Round trip.
+				x, err := c.encrypt(m, key)
+				if err != nil {
+					t.Fatal(c.name, "expected no errors; got", err)
+				}
+				dm, err := c.decrypt(append([]byte{}, x...), key)
+				if err != nil {
+					t.Fatal(c.name, "expected no errors; got", err)
+				}
+				n, err := Unpad(dm)
+				if err != nil || n != 1000 || !bytes.Equal(dm[:n], m[:1000]) {
+					t.Error(c.name, "round trip does not match original; got", n, err)
+				}
+
+				// Wrong key.
+				if _, err := c.decrypt(append([]byte{}, x...), wrong); err != ErrDecryptionFailed {
+					t.Error(c.name, "expected error with incorrect key; got", err)
+				}
+
+				// Tampering, at the start, middle and end of the ciphertext. The trailing newline of a PEM block is not part of the ciphertext.
+				for _, i := range []int{0, len(x) / 2, len(bytes.TrimRight(x, "\n")) - 1} {
+					y := append([]byte{}, x...)
+					y[i] ^= 0x01
+					if _, err := c.decrypt(y, key); err == nil {
+						t.Error(c.name, "accepted ciphertext modified at byte", i)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+)
+
+// ErrInvalidPadding is returned when a buffer given to Unpad does not end in a valid padding sequence.
+var ErrInvalidPadding = errors.New("<gravity::core::ErrInvalidPadding> invalid padding")
+
+// ErrNoRoomForPadding is returned when Pad is given a buffer that has no room left for the padding marker.
+var ErrNoRoomForPadding = errors.New("<gravity::core::ErrNoRoomForPadding> the buffer has no room for the padding marker")
+
+// ErrInvalidLength is returned when Pad is given a negative length.
+var ErrInvalidLength = errors.New("<gravity::core::ErrInvalidLength> length must not be negative")
+
+/*
+Pad pads the first n bytes of a buffer out to the buffer's full length. A single 0x01 marker byte is written directly after the data and every byte after it is zeroed.
+
+Since the marker always takes up a byte, n must be strictly less than the length of the buffer. If it is not, ErrNoRoomForPadding is returned and the buffer is not modified. A negative n is rejected with ErrInvalidLength.
+*/
+func Pad(buf []byte, n int) error {
+	if n < 0 {
+		return ErrInvalidLength
+	}
+	if n >= len(buf) {
+		return ErrNoRoomForPadding
+	}
+	buf[n] = 1
+	for i := n + 1; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return nil
+}
+
+// Unpad returns the length of the data within a buffer padded by Pad. ErrInvalidPadding is returned if the buffer does not contain a marker byte followed only by zeros.
+func Unpad(buf []byte) (int, error) {
+	for k := len(buf) - 1; k >= 0; k-- {
+		switch buf[k] {
+		case 0:
+			continue
+		case 1:
+			return k, nil
+		default:
+			return 0, ErrInvalidPadding
+		}
+	}
+	return 0, ErrInvalidPadding
+}
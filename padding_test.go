@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+func TestPadUnpad(t *testing.T) {
+	const bound = 300
+
+	for padTo := 0; padTo <= bound; padTo++ {
+		// Negative lengths are rejected without touching the buffer.
+		buf := make([]byte, padTo)
+		memguard.ScrambleBytes(buf)
+		orig := append([]byte{}, buf...)
+		for _, n := range []int{-1, -padTo - 1} {
+			if err := Pad(buf, n); err != ErrInvalidLength {
+				t.Fatal("expected ErrInvalidLength for n =", n, "padTo =", padTo, "; got", err)
+			}
+		}
+		if !bytes.Equal(buf, orig) {
+			t.Fatal("buffer modified on error for padTo =", padTo)
+		}
+
+		for n := 0; n <= padTo; n++ {
+			// Construct a buffer whose tail holds garbage that Pad must overwrite.
+			buf := make([]byte, padTo)
+			memguard.ScrambleBytes(buf)
+			text := append([]byte{}, buf[:n]...)
+
+			err := Pad(buf, n)
+			if n == padTo {
+				// The marker does not fit, so the buffer must be left alone.
+				if err != ErrNoRoomForPadding {
+					t.Fatal("expected ErrNoRoomForPadding for n =", n, "padTo =", padTo, "; got", err)
+				}
+				if !bytes.Equal(buf[:n], text) {
+					t.Fatal("buffer modified on error for n =", n)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal("expected no errors for n =", n, "padTo =", padTo, "; got", err)
+			}
+
+			// Check the layout.
+			if buf[n] != 1 {
+				t.Fatal("marker missing for n =", n, "padTo =", padTo)
+			}
+			for _, b := range buf[n+1:] {
+				if b != 0 {
+					t.Fatal("non-zero padding for n =", n, "padTo =", padTo)
+				}
+			}
+
+			// Unpad should recover the original text.
+			length, err := Unpad(buf)
+			if err != nil {
+				t.Fatal("expected no errors for n =", n, "padTo =", padTo, "; got", err)
+			}
+			if length != n || !bytes.Equal(buf[:length], text) {
+				t.Fatal("unpadded text does not match original for n =", n, "padTo =", padTo)
+			}
+		}
+	}
+}
+
+func TestUnpadInvalid(t *testing.T) {
+	const bound = 300
+
+	for size := 0; size <= bound; size++ {
+		// Buffers with no marker are rejected.
+		if n, err := Unpad(make([]byte, size)); err != ErrInvalidPadding || n != 0 {
+			t.Fatal("expected ErrInvalidPadding for all-zero buffer of size", size, "; got", n, err)
+		}
+
+		// Buffers whose last non-zero byte is not the marker are rejected, wherever it sits.
+		for p := 0; p < size; p++ {
+			buf := make([]byte, size)
+			buf[0] = 1
+			buf[p] = byte(2 + p%254)
+			if n, err := Unpad(buf); err != ErrInvalidPadding || n != 0 {
+				t.Fatal("expected ErrInvalidPadding for byte", buf[p], "at position", p, "of", size, "; got", n, err)
+			}
+		}
+	}
+}
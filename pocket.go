@@ -1,10 +1,16 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
 	"unsafe"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/hkdf"
 
 	"github.com/awnumar/memguard"
 )
@@ -16,6 +22,32 @@ const (
 	threads = 4         // by 4 threads
 )
 
+// ErrInvalidRootLength is returned when a KDF backend derives root key material that is not exactly 64 bytes in size.
+var ErrInvalidRootLength = errors.New("<gravity::core::ErrInvalidRootLength> derived root key must be exactly 64 bytes")
+
+/*
+KDF is implemented by key derivation backends. Derive takes a user key and returns 64 bytes of root key material.
+
+Implementations must return the result in a LockedBuffer and must not retain any copies of the input or the output. Since the input is the user's key, any resistance to timing or other side-channel attacks during derivation is the responsibility of the backend.
+*/
+type KDF interface {
+	Derive(key []byte) (*memguard.LockedBuffer, error)
+}
+
+// Argon2 is the default KDF backend. It runs argon2id locally using the parameters above.
+type Argon2 struct{}
+
+// Derive computes the argon2id hash of a given key.
+func (Argon2) Derive(key []byte) (*memguard.LockedBuffer, error) {
+	return memguard.NewBufferFromBytes(argon2.IDKey(key, []byte{}, iters, memory, threads, 64)), nil
+}
+
+// ErrEmptyPassword is returned when attempting to derive a pocket from an empty key, since such a pocket is effectively unprotected.
+var ErrEmptyPassword = errors.New("<gravity::core::ErrEmptyPassword> key must not be empty")
+
+// Backend is the KDF used to derive pockets. It may be replaced to route derivation to an external implementation.
+var Backend KDF = Argon2{}
+
 // Pocket defines a folder within which data can be stored. A particular folder is uniquely identified by a key.
 type Pocket struct {
 	ID  *memguard.Enclave
@@ -23,12 +55,129 @@ type Pocket struct {
 }
 
 // GetPocket takes a key and derives a unique folder within which data may be stored.
-func GetPocket(key *memguard.LockedBuffer) *Pocket {
-	root := memguard.NewBufferFromBytes(argon2.IDKey(key.Bytes(), []byte{}, iters, memory, threads, 64))
-	go key.Destroy()
+func GetPocket(key *memguard.LockedBuffer) (*Pocket, error) {
+	if key.Size() == 0 {
+		key.Destroy()
+		return nil, ErrEmptyPassword
+	}
+
+	root, err := Backend.Derive(key.Bytes())
+	key.Destroy()
+	if err != nil {
+		return nil, err
+	}
 	defer root.Destroy()
+	if root.Size() != 64 {
+		return nil, ErrInvalidRootLength
+	}
 	root.Melt()
-	return &Pocket{memguard.NewEnclave(root.Bytes()[:32]), memguard.NewEnclave(root.Bytes()[32:])}
+	return &Pocket{memguard.NewEnclave(root.Bytes()[:32]), memguard.NewEnclave(root.Bytes()[32:])}, nil
+}
+
+/*
+MeasureUnlockLatency reports how long it takes to unlock a pocket with a given key, from key derivation up to the point the pocket is ready to derive identifiers and to seal and open chunks. It can be used to show users the cost of their chosen parameters.
+
+Nothing derived during the measurement is kept, so the measured time is the same cost as a real unlock. The given key is not modified.
+*/
+func MeasureUnlockLatency(key []byte) (time.Duration, error) {
+	// Copy the key into a secure buffer, since GetPocket destroys its input.
+	k := memguard.NewBuffer(len(key))
+	k.Copy(key)
+
+	start := time.Now()
+	pocket, err := GetPocket(k)
+	if err != nil {
+		return 0, err
+	}
+	_, idMemory, err := pocket.Identifier()
+	if err != nil {
+		return 0, err
+	}
+	keys, err := pocket.ChunkKeys()
+	if err != nil {
+		idMemory.Destroy()
+		return 0, err
+	}
+	elapsed := time.Since(start)
+
+	idMemory.Destroy()
+	keys.Destroy()
+	return elapsed, nil
+}
+
+/*
+CombineKeys derives a single key from two independently held key components, so that a pocket can only be derived with the cooperation of both holders. The result may be passed to GetPocket in place of a user key.
+
+Neither component alone reveals anything about the combined key. Both components are destroyed.
+*/
+func CombineKeys(a, b *memguard.LockedBuffer) *memguard.LockedBuffer {
+	// Prefix the first component with its length so that the split point is unambiguous.
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(a.Size()))
+
+	// Hash the components together. This cannot fail as no key is given.
+	h, _ := blake2b.New256(nil)
+	h.Write(length[:])
+	h.Write(a.Bytes())
+	h.Write(b.Bytes())
+	a.Destroy()
+	b.Destroy()
+
+	// Move the result into a secure buffer.
+	return memguard.NewBufferFromBytes(h.Sum(nil))
+}
+
+// HKDF contexts used to derive the pocket subkeys.
+var (
+	metadataInfo = []byte("gravity metadata v1")
+	contentInfo  = []byte("gravity content v1")
+	armorInfo    = []byte("gravity armor v1")
+)
+
+/*
+Subkeys derives the keys used to seal a pocket's file metadata and file contents respectively.
+
+Both are derived from the pocket key with HKDF-SHA256 under distinct contexts, so a ciphertext sealed under one cannot be opened with the other and either may be rotated independently. The caller is responsible for destroying the returned buffers.
+*/
+func (p *Pocket) Subkeys() (metadata, content *memguard.LockedBuffer, err error) {
+	key, err := p.Key.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer key.Destroy()
+
+	metadata = memguard.NewBuffer(32)
+	content = memguard.NewBuffer(32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key.Bytes(), nil, metadataInfo), metadata.Bytes()); err != nil {
+		metadata.Destroy()
+		content.Destroy()
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key.Bytes(), nil, contentInfo), content.Bytes()); err != nil {
+		metadata.Destroy()
+		content.Destroy()
+		return nil, nil, err
+	}
+	metadata.Freeze()
+	content.Freeze()
+	return metadata, content, nil
+}
+
+// ArmorKey derives the key used to encrypt files to PEM blocks, under its own HKDF-SHA256 context so that armored files and stored chunks never share a key. The caller is responsible for destroying the returned buffer.
+func (p *Pocket) ArmorKey() (*memguard.LockedBuffer, error) {
+	key, err := p.Key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+
+	armor := memguard.NewBuffer(32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key.Bytes(), nil, armorInfo), armor.Bytes()); err != nil {
+		armor.Destroy()
+		return nil, err
+	}
+	armor.Freeze()
+	return armor, nil
 }
 
 // Identifier specifies the values used to derive identifiers.
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
+	"golang.org/x/crypto/blake2b"
+
 	"github.com/awnumar/memguard"
 )
 
@@ -12,7 +16,10 @@ func TestGetPocket(t *testing.T) {
 	key := memguard.NewBufferFromBytes([]byte("yellow submarine"))
 
 	start := time.Now()
-	pocket := GetPocket(key)
+	pocket, err := GetPocket(key)
+	if err != nil {
+		t.Error(err)
+	}
 	if key.IsAlive() {
 		t.Error("key not destroyed")
 	}
@@ -44,3 +51,401 @@ func TestGetPocket(t *testing.T) {
 		t.Error("unexpected key")
 	}
 }
+
+type mockKDF struct {
+	calls int
+	input []byte
+	root  []byte
+	err   error
+}
+
+func (m *mockKDF) Derive(key []byte) (*memguard.LockedBuffer, error) {
+	m.calls++
+	m.input = append([]byte{}, key...)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return memguard.NewBufferFromBytes(append([]byte{}, m.root...)), nil
+}
+
+func TestKDFBackend(t *testing.T) {
+	// Install a mock backend that returns a fixed root.
+	root := make([]byte, 64)
+	memguard.ScrambleBytes(root)
+	mock := &mockKDF{root: root}
+	Backend = mock
+	defer func() { Backend = Argon2{} }()
+
+	pocket, err := GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine")))
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if mock.calls != 1 {
+		t.Error("expected one call to backend; got", mock.calls)
+	}
+	if !bytes.Equal(mock.input, []byte("yellow submarine")) {
+		t.Error("backend received unexpected input")
+	}
+
+	// The pocket should be split from the backend's output.
+	id, err := pocket.ID.Open()
+	if err != nil {
+		t.Error(err)
+	}
+	defer id.Destroy()
+	if !id.EqualTo(root[:32]) {
+		t.Error("unexpected id")
+	}
+	key, err := pocket.Key.Open()
+	if err != nil {
+		t.Error(err)
+	}
+	defer key.Destroy()
+	if !key.EqualTo(root[32:]) {
+		t.Error("unexpected key")
+	}
+
+	// The derived key should work with Encrypt and Decrypt.
+	m := []byte("some secret data")
+	x, err := Encrypt(m, key.Bytes())
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	dm := make([]byte, len(x)-Overhead)
+	if _, err := Decrypt(x, key.Bytes(), dm); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if !bytes.Equal(m, dm) {
+		t.Error("decrypted plaintext does not match original")
+	}
+
+	// Backend errors should be passed through to the caller.
+	mock.err = errors.New("backend unavailable")
+	k := memguard.NewBufferFromBytes([]byte("yellow submarine"))
+	if p, err := GetPocket(k); err != mock.err || p != nil {
+		t.Error("expected backend error; got", err)
+	}
+	if k.IsAlive() {
+		t.Error("key not destroyed")
+	}
+
+	// Roots of the wrong size should be rejected.
+	mock.err = nil
+	mock.root = make([]byte, 32)
+	if p, err := GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine"))); err != ErrInvalidRootLength || p != nil {
+		t.Error("expected ErrInvalidRootLength; got", err)
+	}
+}
+
+func TestCombineKeys(t *testing.T) {
+	partA := make([]byte, 32)
+	memguard.ScrambleBytes(partA)
+	partB := make([]byte, 32)
+	memguard.ScrambleBytes(partB)
+	combine := func(a, b []byte) *memguard.LockedBuffer {
+		return CombineKeys(memguard.NewBufferFromBytes(append([]byte{}, a...)), memguard.NewBufferFromBytes(append([]byte{}, b...)))
+	}
+
+	// Combining the same parts is deterministic.
+	key := combine(partA, partB)
+	defer key.Destroy()
+	if key.Size() != 32 {
+		t.Error("invalid size")
+	}
+	again := combine(partA, partB)
+	defer again.Destroy()
+	if !key.EqualTo(again.Bytes()) {
+		t.Error("combined keys differ")
+	}
+
+	// Neither part alone reproduces the key.
+	if key.EqualTo(partA) || key.EqualTo(partB) {
+		t.Error("combined key equal to a component")
+	}
+	for _, other := range [][2][]byte{
+		{partA, nil},
+		{nil, partB},
+		{partA, partA},
+		{partB, partA},
+		{append(partA, partB[0]), partB[1:]},
+	} {
+		k := combine(other[0], other[1])
+		if key.EqualTo(k.Bytes()) {
+			t.Error("combined key reproduced without both components")
+		}
+		k.Destroy()
+	}
+
+	// The components are destroyed.
+	a := memguard.NewBufferFromBytes(append([]byte{}, partA...))
+	b := memguard.NewBufferFromBytes(append([]byte{}, partB...))
+	CombineKeys(a, b).Destroy()
+	if a.IsAlive() || b.IsAlive() {
+		t.Error("components not destroyed")
+	}
+}
+
+func TestEmptyPassword(t *testing.T) {
+	root := make([]byte, 64)
+	memguard.ScrambleBytes(root)
+	mock := &mockKDF{root: root}
+	Backend = mock
+	defer func() { Backend = Argon2{} }()
+
+	// An empty key is always rejected.
+	if p, err := GetPocket(memguard.NewBufferFromBytes([]byte{})); err != ErrEmptyPassword || p != nil {
+		t.Error("expected ErrEmptyPassword; got", err)
+	}
+	if mock.calls != 0 {
+		t.Error("backend called for rejected key")
+	}
+
+	// A non-empty key is passed through to the backend.
+	p, err := GetPocket(memguard.NewBufferFromBytes([]byte("k")))
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if p == nil {
+		t.Error("expected pocket; got nil")
+	}
+	if mock.calls != 1 {
+		t.Error("expected one call to backend; got", mock.calls)
+	}
+}
+
+type slowKDF struct {
+	delay time.Duration
+}
+
+func (s slowKDF) Derive(key []byte) (*memguard.LockedBuffer, error) {
+	time.Sleep(s.delay)
+	return memguard.NewBufferRandom(64), nil
+}
+
+func TestMeasureUnlockLatency(t *testing.T) {
+	Backend = slowKDF{50 * time.Millisecond}
+	defer func() { Backend = Argon2{} }()
+
+	key := []byte("yellow submarine")
+	latency, err := MeasureUnlockLatency(key)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if latency < 50*time.Millisecond || latency > 5*time.Second {
+		t.Error("implausible unlock latency:", latency)
+	}
+	if !bytes.Equal(key, []byte("yellow submarine")) {
+		t.Error("key was modified")
+	}
+
+	// Errors from unlocking are passed through.
+	if _, err := MeasureUnlockLatency([]byte{}); err != ErrEmptyPassword {
+		t.Error("expected ErrEmptyPassword; got", err)
+	}
+}
+
+func BenchmarkUnlock(b *testing.B) {
+	key := []byte("yellow submarine")
+	for i := 0; i < b.N; i++ {
+		if _, err := MeasureUnlockLatency(key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// fastKDF is a cheap deterministic stand-in for Argon2, for tests of logic above the key derivation. It must never be used outside of tests.
+type fastKDF struct{}
+
+func (fastKDF) Derive(key []byte) (*memguard.LockedBuffer, error) {
+	root := blake2b.Sum512(key)
+	return memguard.NewBufferFromBytes(root[:]), nil
+}
+
+// useFastKDF installs fastKDF as the backend and returns a function that restores the default.
+func useFastKDF() func() {
+	Backend = fastKDF{}
+	return func() { Backend = Argon2{} }
+}
+
+func TestDefaultBackend(t *testing.T) {
+	if _, ok := Backend.(Argon2); !ok {
+		t.Errorf("expected default backend to be Argon2; got %T", Backend)
+	}
+}
+
+func TestPocketSealOpen(t *testing.T) {
+	defer useFastKDF()()
+
+	// Seal an entry within a pocket.
+	pocket, err := GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine")))
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	id, idMemory, err := pocket.Identifier()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer idMemory.Destroy()
+	key, err := pocket.Key.Open()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer key.Destroy()
+	m := []byte("some secret data")
+	if err := Seal(id.Derive(idMemory, 0, 0), m, key.Bytes()); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+
+	// Reopen the same pocket and retrieve the entry.
+	pocket, err = GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine")))
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	id, idMemory, err = pocket.Identifier()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer idMemory.Destroy()
+	out := make([]byte, len(m))
+	n, err := Open(id.Derive(idMemory, 0, 0), key.Bytes(), out)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if n != len(m) || !bytes.Equal(out, m) {
+		t.Error("decrypted plaintext does not match original")
+	}
+
+	// A different pocket does not see the entry.
+	pocket, err = GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarinf")))
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	id, idMemory, err = pocket.Identifier()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer idMemory.Destroy()
+	if _, err := Open(id.Derive(idMemory, 0, 0), key.Bytes(), out); err != ErrEntryNotFound {
+		t.Error("expected ErrEntryNotFound; got", err)
+	}
+}
+
+func TestSubkeys(t *testing.T) {
+	defer useFastKDF()()
+
+	pocket, err := GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine")))
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	metadata, content, err := pocket.Subkeys()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer metadata.Destroy()
+	defer content.Destroy()
+	if metadata.Size() != 32 || content.Size() != 32 {
+		t.Error("invalid size")
+	}
+	if metadata.EqualTo(content.Bytes()) {
+		t.Error("subkeys are equal")
+	}
+
+	// Neither subkey is the pocket key itself.
+	key, err := pocket.Key.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	if metadata.EqualTo(key.Bytes()) || content.EqualTo(key.Bytes()) {
+		t.Error("subkey equal to pocket key")
+	}
+
+	// Derivation is deterministic.
+	metadata2, content2, err := pocket.Subkeys()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer metadata2.Destroy()
+	defer content2.Destroy()
+	if !metadata.EqualTo(metadata2.Bytes()) || !content.EqualTo(content2.Bytes()) {
+		t.Error("subkeys differ between derivations")
+	}
+
+	// Each subkey cannot open entries sealed under the other.
+	id := make([]byte, 32)
+	memguard.ScrambleBytes(id)
+	out := make([]byte, 16)
+	if err := Seal(id, []byte("file contents..."), content.Bytes()); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if _, err := Open(id, metadata.Bytes(), out); err != ErrDecryptionFailed {
+		t.Error("metadata key opened contents; got", err)
+	}
+	if err := Seal(id, []byte("file metadata..."), metadata.Bytes()); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if _, err := Open(id, content.Bytes(), out); err != ErrDecryptionFailed {
+		t.Error("content key opened metadata; got", err)
+	}
+	if _, err := Open(id, metadata.Bytes(), out); err != nil || !bytes.Equal(out, []byte("file metadata...")) {
+		t.Error("failed to open metadata with its own key; got", err)
+	}
+}
+
+func TestArmorKey(t *testing.T) {
+	defer useFastKDF()()
+
+	pocket, err := GetPocket(memguard.NewBufferFromBytes([]byte("yellow submarine")))
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	armor, err := pocket.ArmorKey()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer armor.Destroy()
+	if armor.Size() != 32 {
+		t.Error("invalid size")
+	}
+
+	// The armor key is distinct from every key used for stored chunks.
+	keys, err := pocket.ChunkKeys()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer keys.Destroy()
+	for _, k := range [][]byte{keys.metadata.Bytes(), keys.content.Bytes(), keys.pocket.Bytes()} {
+		if armor.EqualTo(k) {
+			t.Error("armor key equal to a chunk key")
+		}
+	}
+
+	// Derivation is deterministic.
+	armor2, err := pocket.ArmorKey()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer armor2.Destroy()
+	if !armor.EqualTo(armor2.Bytes()) {
+		t.Error("armor key differs between derivations")
+	}
+}
+
+func TestSubkeysKnownAnswer(t *testing.T) {
+	// The pocket key derived from "yellow submarine" in TestGetPocket.
+	pocket := &Pocket{Key: memguard.NewEnclave([]byte{0x74, 0xe5, 0x81, 0xd8, 0x47, 0x41, 0x7e, 0x88, 0x98, 0x55, 0x68, 0x53, 0x9b, 0xc5, 0xd6, 0x4a, 0x7b, 0xeb, 0x82, 0xe3, 0x56, 0x63, 0x17, 0x61, 0xaa, 0xc0, 0x28, 0xf8, 0x70, 0x87, 0x82, 0xbb})}
+	metadata, content, err := pocket.Subkeys()
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer metadata.Destroy()
+	defer content.Destroy()
+	if !metadata.EqualTo([]byte{0x0f, 0x9d, 0xf8, 0x8f, 0xd6, 0x4f, 0xaa, 0x76, 0xa3, 0x6e, 0xc2, 0xd2, 0xe3, 0x96, 0x3f, 0xde, 0x85, 0xe9, 0x83, 0x42, 0x76, 0x17, 0xe1, 0xae, 0x3a, 0xa1, 0x74, 0x3b, 0xc3, 0xa3, 0x53, 0x36}) {
+		fmt.Printf("%#v\n", metadata.Bytes())
+		t.Error("unexpected metadata key")
+	}
+	if !content.EqualTo([]byte{0x97, 0x00, 0xd7, 0xf4, 0xbd, 0x2e, 0xf4, 0xdd, 0x2d, 0x60, 0x4a, 0x4e, 0xe9, 0x6f, 0xd4, 0xbb, 0xe2, 0x0e, 0x95, 0xe9, 0x79, 0x75, 0xb4, 0xa2, 0x5f, 0xad, 0xaf, 0xf8, 0x43, 0x87, 0xa7, 0x13}) {
+		fmt.Printf("%#v\n", content.Bytes())
+		t.Error("unexpected content key")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRandomnessSource(t *testing.T) {
+	// Replace the random source with one that counts how many times it is drawn from.
+	var draws int
+	source := randomBytes
+	randomBytes = func(buf []byte) {
+		draws++
+		source(buf)
+	}
+	defer func() { randomBytes = source }()
+
+	k := make([]byte, 32)
+	source(k)
+	for name, fn := range map[string]func(){
+		"Encrypt":        func() { Encrypt([]byte("message"), k) },
+		"EncryptCascade": func() { EncryptCascade([]byte("message"), k) },
+		"EncryptPEM":     func() { EncryptPEM([]byte("message"), k) },
+		"Seal":           func() { Seal([]byte("random source test"), []byte("message"), k) },
+		"GenerateSecret": func() { GenerateSecret(16, CharsetPolicy{}) },
+	} {
+		draws = 0
+		fn()
+		if draws == 0 {
+			t.Error(name, "does not draw from randomBytes")
+		}
+	}
+}
+
+func TestNoStrayRandomness(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the definition of randomBytes may touch a random source directly.
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range f.Imports {
+			if path, _ := strconv.Unquote(imp.Path.Value); path == "math/rand" || path == "crypto/rand" {
+				t.Error(fset.Position(imp.Pos()), "imports", path, "directly; use randomBytes")
+			}
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+					if x, ok := sel.X.(*ast.Ident); ok && x.Name == "memguard" && (sel.Sel.Name == "ScrambleBytes" || sel.Sel.Name == "NewBufferRandom" || sel.Sel.Name == "NewEnclaveRandom") {
+						t.Error(fset.Position(call.Pos()), "calls memguard."+sel.Sel.Name, "directly; use randomBytes")
+					}
+				}
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+// assertNoRetention calls fn on a copy of each input, then scrambles the copies and checks that the output was unaffected. This catches functions that alias their arguments instead of copying them.
+func assertNoRetention(t *testing.T, name string, fn func(inputs ...[]byte) []byte, inputs ...[]byte) {
+	t.Helper()
+
+	// Call fn on copies of the inputs so that the originals are not disturbed.
+	copies := make([][]byte, len(inputs))
+	for i := range inputs {
+		copies[i] = append([]byte{}, inputs[i]...)
+	}
+	output := fn(copies...)
+	snapshot := append([]byte{}, output...)
+
+	// Mutate the caller's buffers and compare.
+	for i := range copies {
+		memguard.ScrambleBytes(copies[i])
+	}
+	if !bytes.Equal(output, snapshot) {
+		t.Error(name, "retains references to its input")
+	}
+}
+
+func TestNoRetention(t *testing.T) {
+	m := make([]byte, 64)
+	memguard.ScrambleBytes(m)
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+	id := make([]byte, 32)
+	memguard.ScrambleBytes(id)
+
+	assertNoRetention(t, "Encrypt", func(in ...[]byte) []byte {
+		x, err := Encrypt(in[0], in[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	}, m, k)
+
+	assertNoRetention(t, "Decrypt", func(in ...[]byte) []byte {
+		out := make([]byte, len(in[0])-Overhead)
+		if _, err := Decrypt(in[0], in[1], out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}, func() []byte { x, _ := Encrypt(m, k); return x }(), k)
+
+	assertNoRetention(t, "EncryptCascade", func(in ...[]byte) []byte {
+		x, err := EncryptCascade(in[0], in[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	}, m, k)
+
+	assertNoRetention(t, "EncryptPEM", func(in ...[]byte) []byte {
+		x, err := EncryptPEM(in[0], in[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	}, m, k)
+
+	var bound *memguard.LockedBuffer
+	assertNoRetention(t, "BindKey", func(in ...[]byte) []byte {
+		b, err := BindKey(in[0], in[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		bound = b
+		return b.Bytes()
+	}, k, id)
+	bound.Destroy()
+}
+
+// recordingKDF derives with fastKDF, keeping the key slice it was handed and the root it derived from it. It then scrambles the key slice, so that anything the caller takes from the key after derivation comes out wrong.
+type recordingKDF struct {
+	key  []byte
+	root []byte
+}
+
+func (r *recordingKDF) Derive(key []byte) (*memguard.LockedBuffer, error) {
+	root, err := fastKDF{}.Derive(key)
+	if err != nil {
+		return nil, err
+	}
+	r.key = key
+	r.root = append([]byte{}, root.Bytes()...)
+	memguard.ScrambleBytes(key)
+	return root, nil
+}
+
+func TestGetPocketNoRetention(t *testing.T) {
+	// The key slice cannot be scrambled once GetPocket has returned, since GetPocket frees the memory behind it; the backend scrambles it as soon as derivation is done instead.
+	rec := &recordingKDF{}
+	Backend = rec
+	defer func() { Backend = Argon2{} }()
+
+	key := memguard.NewBuffer(16)
+	key.Copy([]byte("yellow submarine"))
+	pocket, err := GetPocket(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.key) != 16 {
+		t.Fatal("backend was not handed the key")
+	}
+	if key.IsAlive() {
+		t.Error("key not destroyed")
+	}
+
+	// The pocket must have been built from the root alone.
+	for _, c := range []struct {
+		name    string
+		enclave *memguard.Enclave
+		want    []byte
+	}{
+		{"ID", pocket.ID, rec.root[:32]},
+		{"Key", pocket.Key, rec.root[32:]},
+	} {
+		b, err := c.enclave.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !b.EqualTo(c.want) {
+			t.Error("GetPocket retains references to its input:", c.name, "changed")
+		}
+		b.Destroy()
+	}
+}
@@ -1,19 +1,66 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
-	"github.com/awnumar/memguard"
 	"github.com/prologic/bitcask"
 )
 
-var database = func() *bitcask.Bitcask {
-	db, err := bitcask.Open("store")
-	if err != nil {
-		memguard.SafePanic(err)
+// ErrEntryNotFound is returned when there is no entry stored under a given identifier.
+var ErrEntryNotFound = errors.New("<gravity::store::ErrEntryNotFound> no entry exists with the given identifier")
+
+// ErrStoreLocked is returned when the database is held open by another process.
+var ErrStoreLocked = errors.New("<gravity::store::ErrStoreLocked> the database is in use by another process")
+
+// lockRetryInterval is how often openStoreTimeout retries a locked database.
+const lockRetryInterval = 50 * time.Millisecond
+
+// database is the disk-backed store used by Put and Get. It is opened by openDB.
+var database *bitcask.Bitcask
+
+// openDB opens the database in the working directory.
+func openDB() (err error) {
+	database, err = OpenStore("store")
+	return
+}
+
+// OpenStore opens the database at the given path. If the database is held open by another process, ErrStoreLocked is returned immediately.
+func OpenStore(path string) (*bitcask.Bitcask, error) {
+	return openStoreTimeout(path, 0)
+}
+
+/*
+openStoreTimeout opens the database at the given path. If the database is held open by another process, it waits up to the given timeout for the lock to be released before returning ErrStoreLocked.
+
+While waiting, the lock is polled with probeLock, which closes the lock file after every attempt so that waiting does not leave file descriptors behind.
+*/
+func openStoreTimeout(path string, timeout time.Duration) (*bitcask.Bitcask, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		free, err := probeLock(filepath.Join(path, "lock"))
+		if err != nil {
+			return nil, err
+		}
+		if free {
+			db, err := bitcask.Open(path)
+			if err != bitcask.ErrDatabaseLocked {
+				return db, err
+			}
+			// Another process took the lock in between; keep waiting.
+		}
+		if !time.Now().Before(deadline) {
+			return nil, ErrStoreLocked
+		}
+		time.Sleep(lockRetryInterval)
 	}
-	return db
-}()
+}
 
 // Put puts a key value pair in the database
 func Put(key, value []byte) error {
@@ -25,6 +72,84 @@ func Get(key []byte) ([]byte, error) {
 	return database.Get(key)
 }
 
+// Seal encrypts a plaintext under a key bound to the given identifier and puts the ciphertext in the database under that identifier.
+func Seal(id, plaintext, key []byte) error {
+	// Check the caller's key, since the bound key will look random either way.
+	if len(key) == 32 && weakKey(key) {
+		return ErrWeakKey
+	}
+
+	k, err := BindKey(key, id)
+	if err != nil {
+		return err
+	}
+	defer k.Destroy()
+
+	ct, err := Encrypt(plaintext, k.Bytes())
+	if err != nil {
+		return err
+	}
+	return Put(id, ct)
+}
+
+// Open gets the ciphertext stored under the given identifier and decrypts it into the given buffer, returning the size of the plaintext.
+func Open(id, key, output []byte) (int, error) {
+	ct, err := Get(id)
+	if err != nil {
+		if err == bitcask.ErrKeyNotFound {
+			return 0, ErrEntryNotFound
+		}
+		return 0, err
+	}
+
+	k, err := BindKey(key, id)
+	if err != nil {
+		return 0, err
+	}
+	defer k.Destroy()
+
+	return Decrypt(ct, k.Bytes(), output)
+}
+
+// SealCascade is like Seal, but encrypts the plaintext with EncryptCascade.
+func SealCascade(id, plaintext, key []byte) error {
+	// Check the caller's key, since the bound key will look random either way.
+	if len(key) == 32 && weakKey(key) {
+		return ErrWeakKey
+	}
+
+	k, err := BindKey(key, id)
+	if err != nil {
+		return err
+	}
+	defer k.Destroy()
+
+	ct, err := EncryptCascade(plaintext, k.Bytes())
+	if err != nil {
+		return err
+	}
+	return Put(id, ct)
+}
+
+// OpenCascade is like Open, but decrypts a ciphertext stored by SealCascade.
+func OpenCascade(id, key, output []byte) (int, error) {
+	ct, err := Get(id)
+	if err != nil {
+		if err == bitcask.ErrKeyNotFound {
+			return 0, ErrEntryNotFound
+		}
+		return 0, err
+	}
+
+	k, err := BindKey(key, id)
+	if err != nil {
+		return 0, err
+	}
+	defer k.Destroy()
+
+	return DecryptCascade(ct, k.Bytes(), output)
+}
+
 func closeDB() {
 	fmt.Println("[i] Compacting database...")
 	database.Merge()
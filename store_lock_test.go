@@ -0,0 +1,91 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOpenStoreLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gravity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Open the database and hold it.
+	db, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+
+	// A second open should fail fast.
+	start := time.Now()
+	if other, err := OpenStore(dir); err != ErrStoreLocked || other != nil {
+		t.Error("expected ErrStoreLocked; got", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("second open did not fail fast; took", elapsed)
+	}
+
+	// A second open with a timeout should wait before failing.
+	start = time.Now()
+	if other, err := openStoreTimeout(dir, 200*time.Millisecond); err != ErrStoreLocked || other != nil {
+		t.Error("expected ErrStoreLocked; got", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Error("did not wait for timeout; took", elapsed)
+	}
+
+	// Release the lock while another open is waiting on it.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		db.Close()
+	}()
+	db, err = openStoreTimeout(dir, 5*time.Second)
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+
+	// The lock is released on close.
+	db.Close()
+	db, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	db.Close()
+}
+
+func TestOpenStoreTimeoutNoLeak(t *testing.T) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open file descriptors:", err)
+	}
+
+	dir, err := ioutil.TempDir("", "gravity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal("expected no errors; got", err)
+	}
+	defer db.Close()
+
+	// Wait through many retries against the held lock.
+	fds, _ = ioutil.ReadDir("/proc/self/fd")
+	before := len(fds)
+	if other, err := openStoreTimeout(dir, 20*lockRetryInterval); err != ErrStoreLocked || other != nil {
+		t.Error("expected ErrStoreLocked; got", err)
+	}
+	fds, _ = ioutil.ReadDir("/proc/self/fd")
+
+	if leaked := len(fds) - before; leaked > 0 {
+		t.Error("file descriptors leaked while waiting:", leaked)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// probeLock reports whether the lock file at the given path is free by briefly taking an exclusive lock on it. The file is closed before returning, whatever the outcome.
+func probeLock(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(new(syscall.Overlapped)))); r == 0 {
+		if err == errorLockViolation {
+			return false, nil
+		}
+		return false, err
+	}
+	if r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(new(syscall.Overlapped)))); r == 0 {
+		return true, err
+	}
+	return true, nil
+}
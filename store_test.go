@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+func TestMain(m *testing.M) {
+	// Run the tests against a temporary database so that nothing is written to the source tree.
+	dir, err := ioutil.TempDir("", "gravity")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	database, err = OpenStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+
+	database.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestSealOpen(t *testing.T) {
+	k := make([]byte, 32)
+	memguard.ScrambleBytes(k)
+
+	// Generate two identifiers and plaintexts.
+	idA, idB := make([]byte, 32), make([]byte, 32)
+	memguard.ScrambleBytes(idA)
+	memguard.ScrambleBytes(idB)
+	mA, mB := make([]byte, 64), make([]byte, 64)
+	memguard.ScrambleBytes(mA)
+	memguard.ScrambleBytes(mB)
+
+	// Seal both entries.
+	if err := Seal(idA, mA, k); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if err := Seal(idB, mB, k); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+
+	// Open them and verify the plaintexts.
+	out := make([]byte, 64)
+	n, err := Open(idA, k, out)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if n != 64 || !bytes.Equal(out, mA) {
+		t.Error("decrypted plaintext does not match original")
+	}
+	n, err = Open(idB, k, out)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if n != 64 || !bytes.Equal(out, mB) {
+		t.Error("decrypted plaintext does not match original")
+	}
+
+	// Swap the stored ciphertexts.
+	ctA, err := Get(idA)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	ctB, err := Get(idB)
+	if err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if err := Put(idA, ctB); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+	if err := Put(idB, ctA); err != nil {
+		t.Error("expected no errors; got", err)
+	}
+
+	// Both entries should now fail to decrypt.
+	if n, err := Open(idA, k, out); err != ErrDecryptionFailed || n != 0 {
+		t.Error("expected decryption failure on relocated ciphertext; got", n, err)
+	}
+	if n, err := Open(idB, k, out); err != ErrDecryptionFailed || n != 0 {
+		t.Error("expected decryption failure on relocated ciphertext; got", n, err)
+	}
+
+	// Attempt to open an entry that does not exist.
+	idC := make([]byte, 32)
+	memguard.ScrambleBytes(idC)
+	if n, err := Open(idC, k, out); err != ErrEntryNotFound || n != 0 {
+		t.Error("expected ErrEntryNotFound; got", n, err)
+	}
+}
+
+func TestSealWeakKey(t *testing.T) {
+	id := make([]byte, 32)
+	memguard.ScrambleBytes(id)
+
+	for _, seal := range []func(id, plaintext, key []byte) error{Seal, SealCascade} {
+		// An all-zero key is rejected even though the bound key derived from it is not all zeros.
+		if err := seal(id, []byte("some secret data"), make([]byte, 32)); err != ErrWeakKey {
+			t.Error("expected ErrWeakKey; got", err)
+		}
+		if _, err := Get(id); err == nil {
+			t.Error("entry stored under weak key")
+		}
+
+		// Keys of invalid length are still reported as such.
+		if err := seal(id, []byte("some secret data"), make([]byte, 16)); err != ErrInvalidKeyLength {
+			t.Error("expected ErrInvalidKeyLength; got", err)
+		}
+	}
+}